@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/hil/ast"
+	"github.com/hashicorp/terraform/config"
+	"github.com/pkg/errors"
+)
+
+// countInfo records how a resourceNode's `count` was resolved. When the count is a literal, known at translation
+// time, instances holds one bare resourceNode per index; ReferenceTransformer builds each instance's own properties,
+// substituting the concrete index for `count.index`. When the count instead depends on a value only known at Pulumi
+// program run time (e.g. a variable or local, as opposed to another resource's attribute), dynamic is set and the
+// original resourceNode is left as a single node flagged for the emitter to lower into a target-language `for` loop.
+// deps holds the dependencies of the count expression itself (e.g. the variable it reads), which
+// ReferenceTransformer merges into every instance's (or, for a dynamic count, the template's) own dependencies.
+type countInfo struct {
+	literal   int
+	dynamic   bool
+	deps      []node
+	instances []*resourceNode
+}
+
+// CountBoundaryTransformer determines whether every resourceNode's `config.RawCount` evaluates to a literal integer
+// and, if so, creates its N indexed instances (`foo[0]`...`foo[N-1]`); ReferenceTransformer fills in each instance's
+// properties afterward, once it can substitute the concrete index for `count.index`. A count that depends on a
+// runtime value is instead left as a single node flagged as a dynamic count, provided that value isn't itself a
+// resource attribute--Pulumi has to know how many resources to create before any of them run, so a count driven by
+// another resource's (as-yet-unknown) output, whether referenced directly or indirectly through a module output,
+// can never be satisfied.
+type CountBoundaryTransformer struct{}
+
+func (t *CountBoundaryTransformer) Transform(g *graph) error {
+	b := g.b
+	for _, r := range b.resources {
+		if r.config.RawCount == nil {
+			continue
+		}
+
+		props, deps, err := b.buildProperties(r.config.RawCount)
+		if err != nil {
+			return errors.Wrapf(err, "evaluating count for %v", r.config.Id())
+		}
+		countDeps := b.buildDeps(deps)
+
+		count, ok := props["count"]
+		if !ok {
+			continue
+		}
+
+		literal, isLiteral := literalCount(count)
+		if !isLiteral {
+			for _, d := range countDeps {
+				if dependsOnResource(d) {
+					return errors.Errorf(
+						"count for %v depends on a resource attribute, which Pulumi cannot use to decide how many "+
+							"resources to create", r.config.Id())
+				}
+			}
+			r.count = &countInfo{dynamic: true, deps: countDeps}
+			continue
+		}
+		if literal < 0 {
+			return errors.Errorf("count for %v may not be negative (got %d)", r.config.Id(), literal)
+		}
+
+		instances := make([]*resourceNode, literal)
+		for i := 0; i < literal; i++ {
+			instances[i] = &resourceNode{config: r.config, provider: r.provider, index: i}
+		}
+		r.count = &countInfo{literal: literal, deps: countDeps, instances: instances}
+	}
+	return nil
+}
+
+// dependsOnResource reports whether n is a *resourceNode or transitively depends--through any chain of outputs,
+// locals, variables, or module outputs--on one. A count expression that reaches a resource attribute this way (most
+// commonly through a `module.foo.bar` output that was itself built from one of the module's resources) is just as
+// unsatisfiable as one that references the resource directly.
+func dependsOnResource(n node) bool {
+	seen := make(map[node]bool)
+	var visit func(node) bool
+	visit = func(n node) bool {
+		if seen[n] {
+			return false
+		}
+		seen[n] = true
+		if _, ok := n.(*resourceNode); ok {
+			return true
+		}
+		for _, d := range n.dependencies() {
+			if visit(d) {
+				return true
+			}
+		}
+		return false
+	}
+	return visit(n)
+}
+
+// literalCount coerces a count expression's resolved value to an int, accepting both the float64 HIL represents
+// numbers as and a literal string of digits.
+func literalCount(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int(n), true
+	case int:
+		return n, true
+	case string:
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, false
+		}
+		return i, true
+	default:
+		return 0, false
+	}
+}
+
+// substituteCountIndex walks an already-built properties tree, replacing every `count.index` reference with the
+// literal index. An expression that is nothing but a `count.index` reference becomes the bare index value; a larger
+// expression around one (e.g. `count.index + 1`) keeps its AST, with the literal index in place, and is not evaluated.
+func substituteCountIndex(properties map[string]interface{}, index int) (map[string]interface{}, error) {
+	substituted, err := substituteCountIndexValue(properties, index)
+	if err != nil {
+		return nil, err
+	}
+	return substituted.(map[string]interface{}), nil
+}
+
+func substituteCountIndexValue(v interface{}, index int) (interface{}, error) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for k, e := range value {
+			substituted, err := substituteCountIndexValue(e, index)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = substituted
+		}
+		return result, nil
+
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, e := range value {
+			substituted, err := substituteCountIndexValue(e, index)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = substituted
+		}
+		return result, nil
+
+	case ast.Node:
+		var visitErr error
+		rewritten := value.Accept(func(n ast.Node) ast.Node {
+			va, ok := n.(*ast.VariableAccess)
+			if !ok {
+				return n
+			}
+			tfVar, err := config.NewInterpolatedVariable(va.Name)
+			if err != nil {
+				visitErr = err
+				return n
+			}
+			if _, ok := tfVar.(*config.CountVariable); !ok {
+				return n
+			}
+			return &ast.LiteralNode{Value: float64(index), Typex: ast.TypeInt, Posx: va.Pos()}
+		})
+		if visitErr != nil {
+			return nil, visitErr
+		}
+		if lit, ok := rewritten.(*ast.LiteralNode); ok {
+			return lit.Value, nil
+		}
+		return rewritten, nil
+
+	default:
+		return value, nil
+	}
+}
+
+// ilSplatVariableAccess replaces a splat-style resource reference (`aws_instance.foo.*.id`) once it has been parsed,
+// so the emitter can recognize it and lower it to the target language's own map-over-instances equivalent (e.g.
+// `foos.map(f => f.id)`) instead of the single-instance indexing a plain *ast.VariableAccess would suggest.
+type ilSplatVariableAccess struct {
+	Name string
+	Posx ast.Pos
+}
+
+func (v *ilSplatVariableAccess) Pos() ast.Pos { return v.Posx }
+
+func (v *ilSplatVariableAccess) Type(ast.Scope) (ast.Type, error) { return ast.TypeList, nil }
+
+func (v *ilSplatVariableAccess) GoString() string {
+	return fmt.Sprintf("*main.ilSplatVariableAccess(%s)", v.Name)
+}
+
+func (v *ilSplatVariableAccess) Accept(visitor ast.Visitor) ast.Node { return visitor(v) }
+
+// rewriteSplatAccess replaces every *ast.VariableAccess in root that names a splat-style resource reference with an
+// *ilSplatVariableAccess.
+func rewriteSplatAccess(root ast.Node) (ast.Node, error) {
+	var rewriteErr error
+	result := root.Accept(func(n ast.Node) ast.Node {
+		if rewriteErr != nil {
+			return n
+		}
+		va, ok := n.(*ast.VariableAccess)
+		if !ok {
+			return n
+		}
+		tfVar, err := config.NewInterpolatedVariable(va.Name)
+		if err != nil {
+			rewriteErr = err
+			return n
+		}
+		rv, ok := tfVar.(*config.ResourceVariable)
+		if !ok || !rv.Multi || rv.Index != -1 {
+			return n
+		}
+		return &ilSplatVariableAccess{Name: va.Name, Posx: va.Pos()}
+	})
+	if rewriteErr != nil {
+		return nil, rewriteErr
+	}
+	return result, nil
+}
+
+// addResourceDeps records the dependency edges a `*config.ResourceVariable` reference to r should contribute. A
+// splat reference to a count-expanded resource depends on every instance; a direct reference to a specific index
+// depends on just that instance; anything else (no count, or a still-dynamic count) depends on r itself.
+func (b *builder) addResourceDeps(deps map[node]struct{}, r *resourceNode, v *config.ResourceVariable) error {
+	if r.count == nil || r.count.dynamic {
+		deps[r] = struct{}{}
+		return nil
+	}
+
+	if v.Multi && v.Index == -1 {
+		for _, instance := range r.count.instances {
+			deps[instance] = struct{}{}
+		}
+		return nil
+	}
+
+	index := v.Index
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(r.count.instances) {
+		return errors.Errorf("%v does not have an instance at index %d", r.config.Id(), index)
+	}
+	deps[r.count.instances[index]] = struct{}{}
+	return nil
+}
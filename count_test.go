@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hil/ast"
+)
+
+func TestLiteralCount(t *testing.T) {
+	cases := []struct {
+		in        interface{}
+		want      int
+		wantIsLit bool
+	}{
+		{float64(3), 3, true},
+		{2, 2, true},
+		{"4", 4, true},
+		{"not-a-number", 0, false},
+		{true, 0, false},
+	}
+	for _, c := range cases {
+		got, isLit := literalCount(c.in)
+		if isLit != c.wantIsLit || (isLit && got != c.want) {
+			t.Fatalf("literalCount(%#v) = (%d, %v), want (%d, %v)", c.in, got, isLit, c.want, c.wantIsLit)
+		}
+	}
+}
+
+func TestDependsOnResourceDirect(t *testing.T) {
+	if !dependsOnResource(&resourceNode{}) {
+		t.Fatal("expected a *resourceNode to depend on itself")
+	}
+}
+
+func TestDependsOnResourceTransitive(t *testing.T) {
+	r := &resourceNode{}
+	l := &localNode{deps: []node{r}}
+	o := &outputNode{deps: []node{l}}
+	if !dependsOnResource(o) {
+		t.Fatal("expected an output depending transitively on a resource to report true")
+	}
+}
+
+func TestDependsOnResourceFalse(t *testing.T) {
+	l := &localNode{deps: []node{&variableNode{}}}
+	if dependsOnResource(l) {
+		t.Fatal("expected a local depending only on a variable to report false")
+	}
+}
+
+func TestDependsOnResourceCycleSafe(t *testing.T) {
+	a, b := &localNode{}, &localNode{}
+	a.deps = []node{b}
+	b.deps = []node{a}
+	if dependsOnResource(a) {
+		t.Fatal("expected a cycle with no resource in it to report false")
+	}
+}
+
+func TestSubstituteCountIndex(t *testing.T) {
+	properties := map[string]interface{}{
+		"id": &ast.VariableAccess{Name: "count.index"},
+		"tags": []interface{}{
+			&ast.VariableAccess{Name: "count.index"},
+			"static",
+		},
+	}
+	substituted, err := substituteCountIndex(properties, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := substituted["id"]; v != float64(2) {
+		t.Fatalf("expected id to be substituted with 2, got %#v", v)
+	}
+	tags := substituted["tags"].([]interface{})
+	if tags[0] != float64(2) || tags[1] != "static" {
+		t.Fatalf("unexpected substituted tags: %#v", tags)
+	}
+}
+
+func TestSubstituteCountIndexLeavesOtherVariablesAlone(t *testing.T) {
+	properties := map[string]interface{}{"name": &ast.VariableAccess{Name: "var.x"}}
+	substituted, err := substituteCountIndex(properties, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	va, ok := substituted["name"].(*ast.VariableAccess)
+	if !ok || va.Name != "var.x" {
+		t.Fatalf("expected a non-count.index variable access to be left untouched, got %#v", substituted["name"])
+	}
+}
+
+func TestRewriteSplatAccess(t *testing.T) {
+	root := &ast.VariableAccess{Name: "aws_instance.foo.*.id"}
+	rewritten, err := rewriteSplatAccess(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	splat, ok := rewritten.(*ilSplatVariableAccess)
+	if !ok || splat.Name != "aws_instance.foo.*.id" {
+		t.Fatalf("expected a splat reference to be rewritten to an *ilSplatVariableAccess, got %#v", rewritten)
+	}
+}
+
+func TestRewriteSplatAccessLeavesIndexedReferenceAlone(t *testing.T) {
+	root := &ast.VariableAccess{Name: "aws_instance.foo.0.id"}
+	rewritten, err := rewriteSplatAccess(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	va, ok := rewritten.(*ast.VariableAccess)
+	if !ok || va.Name != "aws_instance.foo.0.id" {
+		t.Fatalf("expected an indexed reference to be left untouched, got %#v", rewritten)
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hil/ast"
+	"github.com/pkg/errors"
+)
+
+// ilCall is a tagged node produced in place of a *ast.Call whose callee is a recognized Terraform builtin but whose
+// arguments are not all known at translation time. Kind identifies which Pulumi-side construct the emitter should
+// lower this node into--e.g. "format" becomes a template literal, "readFile" becomes fs.readFileSync, "lookup"
+// becomes an indexer with a default, and "element" becomes a modulo index.
+type ilCall struct {
+	Kind string
+	Args []ast.Node
+	Posx ast.Pos
+}
+
+func (c *ilCall) Pos() ast.Pos { return c.Posx }
+
+func (c *ilCall) Type(ast.Scope) (ast.Type, error) { return ast.TypeInvalid, nil }
+
+func (c *ilCall) GoString() string { return fmt.Sprintf("*main.ilCall(%s)", c.Kind) }
+
+// Accept recurses into the call's arguments before visiting the call itself, mirroring *ast.Call's own traversal
+// order, so that passes which walk the rewritten tree (e.g. dependency collection) still see every nested
+// VariableAccess.
+func (c *ilCall) Accept(v ast.Visitor) ast.Node {
+	for i, a := range c.Args {
+		c.Args[i] = a.Accept(v)
+	}
+	return v(c)
+}
+
+// funcTranslator rewrites a parsed call to a Terraform builtin into either a literal node (when every argument is
+// known at translation time) or an *ilCall the emitter can lower into the target language later.
+type funcTranslator func(call *ast.Call) (ast.Node, error)
+
+// funcTranslators maps Terraform interpolation function names to their translators. It is intentionally mutable at
+// package scope--RegisterFunction lets callers plug in translations for user-defined or as-yet-unsupported
+// functions without forking this file.
+var funcTranslators = map[string]funcTranslator{
+	"file":           translateFile,
+	"format":         translateFormat,
+	"formatlist":     translateFormatList,
+	"join":           translateJoin,
+	"split":          translateSplit,
+	"element":        translateElement,
+	"length":         translateLength,
+	"lookup":         translateLookup,
+	"merge":          translateMerge,
+	"concat":         translateConcat,
+	"coalesce":       translateCoalesce,
+	"jsonencode":     translateJSONEncode,
+	"base64encode":   translateBase64Encode,
+	"base64decode":   translateBase64Decode,
+	"sha1":           translateSha1,
+	"sha256":         translateSha256,
+	"substr":         opaqueTranslator("substr"),
+	"replace":        opaqueTranslator("replace"),
+	"cidrsubnet":     opaqueTranslator("cidrsubnet"),
+	"uuid":           opaqueTranslator("uuid"),
+	"timestamp":      opaqueTranslator("timestamp"),
+}
+
+// RegisterFunction adds or replaces the translator used for the named Terraform interpolation function.
+func RegisterFunction(name string, translate funcTranslator) {
+	funcTranslators[name] = translate
+}
+
+// opaqueTranslator builds a translator for functions this package does not evaluate at translation time--either
+// because they are nondeterministic (uuid, timestamp) or algorithmically involved enough (cidrsubnet) that the
+// target language's own standard library is a better fit than reimplementing it here. Such calls are always
+// deferred to the emitter as kind-tagged nodes.
+func opaqueTranslator(kind string) funcTranslator {
+	return func(call *ast.Call) (ast.Node, error) {
+		return &ilCall{Kind: kind, Args: call.Args, Posx: call.Pos()}, nil
+	}
+}
+
+// translateFunctions rewrites every *ast.Call in root that names a Terraform builtin into either its compile-time
+// result or an *ilCall for the emitter, and reports an error naming the function and its source position for any
+// call that is not a recognized builtin (rather than silently passing the call through unchanged).
+func translateFunctions(root ast.Node) (ast.Node, error) {
+	var translateErr error
+	result := root.Accept(func(n ast.Node) ast.Node {
+		if translateErr != nil {
+			return n
+		}
+		call, ok := n.(*ast.Call)
+		if !ok {
+			return n
+		}
+		translate, ok := funcTranslators[call.Func]
+		if !ok {
+			translateErr = errors.Errorf("unsupported interpolation function %q at %v", call.Func, call.Pos())
+			return n
+		}
+		translated, err := translate(call)
+		if err != nil {
+			translateErr = errors.Wrapf(err, "translating call to %q at %v", call.Func, call.Pos())
+			return n
+		}
+		return translated
+	})
+	if translateErr != nil {
+		return nil, translateErr
+	}
+	return result, nil
+}
+
+func literalNode(value interface{}, pos ast.Pos) *ast.LiteralNode {
+	return &ast.LiteralNode{Value: value, Typex: astTypeOf(value), Posx: pos}
+}
+
+func astTypeOf(v interface{}) ast.Type {
+	switch v.(type) {
+	case bool:
+		return ast.TypeBool
+	case int, float64:
+		return ast.TypeFloat
+	case string:
+		return ast.TypeString
+	case []interface{}:
+		return ast.TypeList
+	case map[string]interface{}:
+		return ast.TypeMap
+	default:
+		return ast.TypeInvalid
+	}
+}
+
+// literalArgs returns the resolved values of args if every one of them is a literal node, and false otherwise.
+func literalArgs(args []ast.Node) ([]interface{}, bool) {
+	values := make([]interface{}, len(args))
+	for i, a := range args {
+		lit, ok := a.(*ast.LiteralNode)
+		if !ok {
+			return nil, false
+		}
+		values[i] = lit.Value
+	}
+	return values, true
+}
+
+func asString(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", errors.Errorf("expected a string, got %T", v)
+	}
+	return s, nil
+}
+
+func asList(v interface{}) ([]interface{}, error) {
+	l, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("expected a list, got %T", v)
+	}
+	return l, nil
+}
+
+func asMap(v interface{}) (map[string]interface{}, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("expected a map, got %T", v)
+	}
+	return m, nil
+}
+
+func translateFile(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		// The path is only known at runtime; defer to the emitter, which renders this as fs.readFileSync.
+		return &ilCall{Kind: "readFile", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	path, err := asString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading file %q", path)
+	}
+	return literalNode(string(contents), call.Pos()), nil
+}
+
+func translateFormat(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		// Rendered by the emitter as a JS/TS template literal or Python f-string.
+		return &ilCall{Kind: "format", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	format, err := asString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	formatArgs := make([]interface{}, len(args)-1)
+	for i, a := range args[1:] {
+		formatArgs[i] = normalizeFormatArg(a)
+	}
+	return literalNode(fmt.Sprintf(format, formatArgs...), call.Pos()), nil
+}
+
+// normalizeFormatArg converts a whole-valued float64--which is how buildValue represents every HIL number,
+// including ones written as plain integers in the source--back to an int64 so that a %d verb in a format() string
+// behaves the way it does in Terraform, instead of fmt.Sprintf printing the Go-specific "%!d(float64=...)" mismatch
+// error for an argument that is, as far as the configuration author is concerned, an integer.
+func normalizeFormatArg(v interface{}) interface{} {
+	if f, ok := v.(float64); ok && f == math.Trunc(f) {
+		return int64(f)
+	}
+	return v
+}
+
+func translateFormatList(call *ast.Call) (ast.Node, error) {
+	// formatlist broadcasts format() over list arguments; always left for the emitter, which lowers it to a map
+	// over the longest input list.
+	return &ilCall{Kind: "formatList", Args: call.Args, Posx: call.Pos()}, nil
+}
+
+func translateJoin(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		return &ilCall{Kind: "join", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	sep, err := asString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	elems := make([]string, 0)
+	for _, a := range args[1:] {
+		l, err := asList(a)
+		if err != nil {
+			s, serr := asString(a)
+			if serr != nil {
+				return nil, err
+			}
+			elems = append(elems, s)
+			continue
+		}
+		for _, e := range l {
+			s, err := asString(e)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, s)
+		}
+	}
+	return literalNode(strings.Join(elems, sep), call.Pos()), nil
+}
+
+func translateSplit(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		return &ilCall{Kind: "split", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	sep, err := asString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	s, err := asString(args[1])
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(s, sep)
+	result := make([]interface{}, len(parts))
+	for i, p := range parts {
+		result[i] = p
+	}
+	return literalNode(result, call.Pos()), nil
+}
+
+func translateElement(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		// Rendered by the emitter as a modulo-wrapped index: list[index % list.length].
+		return &ilCall{Kind: "element", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	list, err := asList(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, errors.New("element() may not be used with an empty list")
+	}
+	index, err := asIndex(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return literalNode(list[index%len(list)], call.Pos()), nil
+}
+
+func asIndex(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case float64:
+		return int(n), nil
+	case string:
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, errors.Wrapf(err, "expected an integer index, got %q", n)
+		}
+		return i, nil
+	default:
+		return 0, errors.Errorf("expected an integer index, got %T", v)
+	}
+}
+
+func translateLength(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		return &ilCall{Kind: "length", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	switch v := args[0].(type) {
+	case string:
+		return literalNode(float64(len(v)), call.Pos()), nil
+	case []interface{}:
+		return literalNode(float64(len(v)), call.Pos()), nil
+	case map[string]interface{}:
+		return literalNode(float64(len(v)), call.Pos()), nil
+	default:
+		return nil, errors.Errorf("length() requires a string, list, or map, got %T", v)
+	}
+}
+
+func translateLookup(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		// Rendered by the emitter as map[key] ?? default (or an equivalent indexer-with-default).
+		return &ilCall{Kind: "lookup", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	m, err := asMap(args[0])
+	if err != nil {
+		return nil, err
+	}
+	key, err := asString(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := m[key]; ok {
+		return literalNode(v, call.Pos()), nil
+	}
+	if len(args) > 2 {
+		return literalNode(args[2], call.Pos()), nil
+	}
+	return nil, errors.Errorf("lookup() key %q not found and no default was given", key)
+}
+
+func translateMerge(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		return &ilCall{Kind: "merge", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	result := make(map[string]interface{})
+	for _, a := range args {
+		m, err := asMap(a)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range m {
+			result[k] = v
+		}
+	}
+	return literalNode(result, call.Pos()), nil
+}
+
+func translateConcat(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		return &ilCall{Kind: "concat", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	result := make([]interface{}, 0)
+	for _, a := range args {
+		l, err := asList(a)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, l...)
+	}
+	return literalNode(result, call.Pos()), nil
+}
+
+func translateCoalesce(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		// Rendered by the emitter as a chain of `??` (or an equivalent first-non-null helper).
+		return &ilCall{Kind: "coalesce", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	for _, a := range args {
+		if s, ok := a.(string); !ok || s != "" {
+			return literalNode(a, call.Pos()), nil
+		}
+	}
+	return nil, errors.New("coalesce() requires at least one non-empty argument")
+}
+
+func translateJSONEncode(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		return &ilCall{Kind: "jsonEncode", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	encoded, err := json.Marshal(args[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "jsonencode()")
+	}
+	return literalNode(string(encoded), call.Pos()), nil
+}
+
+func translateBase64Encode(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		return &ilCall{Kind: "base64Encode", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	s, err := asString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return literalNode(base64.StdEncoding.EncodeToString([]byte(s)), call.Pos()), nil
+}
+
+func translateBase64Decode(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		return &ilCall{Kind: "base64Decode", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	s, err := asString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "base64decode()")
+	}
+	return literalNode(string(decoded), call.Pos()), nil
+}
+
+func translateSha1(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		return &ilCall{Kind: "sha1", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	s, err := asString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(s))
+	return literalNode(hex.EncodeToString(sum[:]), call.Pos()), nil
+}
+
+func translateSha256(call *ast.Call) (ast.Node, error) {
+	args, ok := literalArgs(call.Args)
+	if !ok {
+		return &ilCall{Kind: "sha256", Args: call.Args, Posx: call.Pos()}, nil
+	}
+	s, err := asString(args[0])
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(s))
+	return literalNode(hex.EncodeToString(sum[:]), call.Pos()), nil
+}
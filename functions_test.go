@@ -0,0 +1,226 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hil/ast"
+)
+
+func litArg(v interface{}) ast.Node {
+	return literalNode(v, ast.Pos{})
+}
+
+func callOf(name string, args ...ast.Node) *ast.Call {
+	return &ast.Call{Func: name, Args: args, Posx: ast.Pos{}}
+}
+
+// literalValue asserts that n is an *ast.LiteralNode and returns its Value, failing the test otherwise.
+func literalValue(t *testing.T, n ast.Node) interface{} {
+	t.Helper()
+	lit, ok := n.(*ast.LiteralNode)
+	if !ok {
+		t.Fatalf("expected a literal node, got %T", n)
+	}
+	return lit.Value
+}
+
+func TestTranslateFormat(t *testing.T) {
+	n, err := translateFormat(callOf("format", litArg("%s-%d"), litArg("x"), litArg(float64(3))))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := literalValue(t, n); v != "x-3" {
+		t.Fatalf("expected %q, got %q", "x-3", v)
+	}
+}
+
+func TestTranslateFormatDeferredWhenArgsNotLiteral(t *testing.T) {
+	n, err := translateFormat(callOf("format", litArg("%s"), &ast.VariableAccess{Name: "var.x"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	call, ok := n.(*ilCall)
+	if !ok || call.Kind != "format" {
+		t.Fatalf("expected an ilCall{Kind: \"format\"}, got %#v", n)
+	}
+}
+
+func TestTranslateJoin(t *testing.T) {
+	n, err := translateJoin(callOf("join", litArg(","), litArg([]interface{}{"a", "b"}), litArg("c")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := literalValue(t, n); v != "a,b,c" {
+		t.Fatalf("expected %q, got %q", "a,b,c", v)
+	}
+}
+
+func TestTranslateSplit(t *testing.T) {
+	n, err := translateSplit(callOf("split", litArg(","), litArg("a,b,c")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v := literalValue(t, n).([]interface{})
+	if len(v) != 3 || v[0] != "a" || v[1] != "b" || v[2] != "c" {
+		t.Fatalf("unexpected split result: %#v", v)
+	}
+}
+
+func TestTranslateElement(t *testing.T) {
+	n, err := translateElement(callOf("element", litArg([]interface{}{"a", "b", "c"}), litArg(float64(4))))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := literalValue(t, n); v != "b" {
+		t.Fatalf("expected wraparound index to give %q, got %q", "b", v)
+	}
+}
+
+func TestTranslateElementEmptyList(t *testing.T) {
+	_, err := translateElement(callOf("element", litArg([]interface{}{}), litArg(float64(0))))
+	if err == nil {
+		t.Fatal("expected an error for element() on an empty list")
+	}
+}
+
+func TestTranslateLength(t *testing.T) {
+	n, err := translateLength(callOf("length", litArg([]interface{}{"a", "b"})))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := literalValue(t, n); v != float64(2) {
+		t.Fatalf("expected 2, got %v", v)
+	}
+}
+
+func TestTranslateLookup(t *testing.T) {
+	m := map[string]interface{}{"k": "v"}
+	n, err := translateLookup(callOf("lookup", litArg(m), litArg("k")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := literalValue(t, n); v != "v" {
+		t.Fatalf("expected %q, got %q", "v", v)
+	}
+}
+
+func TestTranslateLookupMissingKeyWithDefault(t *testing.T) {
+	m := map[string]interface{}{"k": "v"}
+	n, err := translateLookup(callOf("lookup", litArg(m), litArg("missing"), litArg("fallback")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := literalValue(t, n); v != "fallback" {
+		t.Fatalf("expected %q, got %q", "fallback", v)
+	}
+}
+
+func TestTranslateLookupMissingKeyNoDefault(t *testing.T) {
+	m := map[string]interface{}{"k": "v"}
+	_, err := translateLookup(callOf("lookup", litArg(m), litArg("missing")))
+	if err == nil {
+		t.Fatal("expected an error for a missing lookup() key with no default")
+	}
+}
+
+func TestTranslateMerge(t *testing.T) {
+	a := map[string]interface{}{"k1": "v1"}
+	b := map[string]interface{}{"k2": "v2", "k1": "override"}
+	n, err := translateMerge(callOf("merge", litArg(a), litArg(b)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v := literalValue(t, n).(map[string]interface{})
+	if v["k1"] != "override" || v["k2"] != "v2" {
+		t.Fatalf("unexpected merge result: %#v", v)
+	}
+}
+
+func TestTranslateConcat(t *testing.T) {
+	n, err := translateConcat(callOf("concat", litArg([]interface{}{"a"}), litArg([]interface{}{"b", "c"})))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v := literalValue(t, n).([]interface{})
+	if len(v) != 3 || v[0] != "a" || v[1] != "b" || v[2] != "c" {
+		t.Fatalf("unexpected concat result: %#v", v)
+	}
+}
+
+func TestTranslateCoalesce(t *testing.T) {
+	n, err := translateCoalesce(callOf("coalesce", litArg(""), litArg(""), litArg("first")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := literalValue(t, n); v != "first" {
+		t.Fatalf("expected %q, got %q", "first", v)
+	}
+}
+
+func TestTranslateCoalesceAllEmpty(t *testing.T) {
+	_, err := translateCoalesce(callOf("coalesce", litArg(""), litArg("")))
+	if err == nil {
+		t.Fatal("expected an error when every coalesce() argument is empty")
+	}
+}
+
+func TestTranslateBase64RoundTrip(t *testing.T) {
+	encoded, err := translateBase64Encode(callOf("base64encode", litArg("hello")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	encodedValue := literalValue(t, encoded).(string)
+
+	decoded, err := translateBase64Decode(callOf("base64decode", litArg(encodedValue)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := literalValue(t, decoded); v != "hello" {
+		t.Fatalf("expected round trip to recover %q, got %q", "hello", v)
+	}
+}
+
+func TestTranslateSha1AndSha256(t *testing.T) {
+	sha1Node, err := translateSha1(callOf("sha1", litArg("hello")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := literalValue(t, sha1Node); v != "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d" {
+		t.Fatalf("unexpected sha1 digest: %v", v)
+	}
+
+	sha256Node, err := translateSha256(callOf("sha256", litArg("hello")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := literalValue(t, sha256Node); v != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Fatalf("unexpected sha256 digest: %v", v)
+	}
+}
+
+func TestTranslateJSONEncode(t *testing.T) {
+	n, err := translateJSONEncode(callOf("jsonencode", litArg(map[string]interface{}{"k": "v"})))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := literalValue(t, n); v != `{"k":"v"}` {
+		t.Fatalf("unexpected json: %v", v)
+	}
+}
+
+func TestLiteralArgs(t *testing.T) {
+	if _, ok := literalArgs([]ast.Node{litArg("a"), &ast.VariableAccess{Name: "var.x"}}); ok {
+		t.Fatal("expected literalArgs to report false when any argument is not a literal")
+	}
+	values, ok := literalArgs([]ast.Node{litArg("a"), litArg(float64(1))})
+	if !ok || len(values) != 2 || values[0] != "a" || values[1] != float64(1) {
+		t.Fatalf("unexpected literalArgs result: %#v, %v", values, ok)
+	}
+}
+
+func TestTranslateFunctionsUnsupportedFunction(t *testing.T) {
+	_, err := translateFunctions(callOf("not_a_real_function"))
+	if err == nil {
+		t.Fatal("expected an error for an unrecognized interpolation function")
+	}
+}
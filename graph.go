@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GraphDotOpts controls how GraphDot renders a graph.
+type GraphDotOpts struct {
+	// ModuleDepth bounds how many levels of child modules are expanded into their own subgraphs. -1 (what GraphDot
+	// uses when opts is nil) expands every module; 0, the field's zero value, draws each module as a single collapsed
+	// node with no internal detail.
+	ModuleDepth int
+	// DrawCycles, when set, runs a dependency-cycle check over the graph and highlights any cyclic edge in red.
+	// Terraform configurations should never actually contain a cycle, and buildGraph does not check for one, so this
+	// is useful both for such input and when debugging a GraphTransformer that introduces one by mistake.
+	DrawCycles bool
+	// Verbose annotates each resource node with its resolved Pulumi type token, taken from the owning provider's
+	// providerNode.info.Resources, instead of just its Terraform type and name.
+	Verbose bool
+}
+
+// GraphDot renders g as a Graphviz `digraph` whose edges point from each node to what it depends on: resource->
+// provider, resource->resource (via deps), consumer->local/variable, and output->resource, styled differently for
+// implicit HIL-derived edges than for `depends_on`-derived explicit edges. It is meant to let a caller diff the structure tf2pulumi derived from a
+// Terraform configuration against `terraform graph`'s own output while debugging a conversion.
+//
+// This snapshot of the tree has no cmd/ package or other CLI entrypoint at all, for any subcommand, so a
+// `tf2pulumi graph` subcommand that calls this is not wired up here; see the chunk0-6 fix commit message for why
+// that's out of scope for this change rather than silently dropped.
+func GraphDot(g *graph, opts *GraphDotOpts) string {
+	if opts == nil {
+		opts = &GraphDotOpts{ModuleDepth: -1}
+	}
+
+	// Dependency edges can cross a module boundary (a parent output or resource can depend on a child module's
+	// output, and vice versa via a module's inputs), so every node's Graphviz ID is resolved from its own owning
+	// module's prefix, computed once up front, rather than assumed to share whichever prefix is in scope when the
+	// edge is written.
+	prefixes := make(map[node]string)
+	collectPrefixes(g, "", opts.ModuleDepth, prefixes)
+
+	var b strings.Builder
+	b.WriteString("digraph G {\n")
+	emitModuleGraph(&b, g, "", opts, prefixes)
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// collectPrefixes walks g and its expanded child modules (mirroring the ModuleDepth-bounded recursion
+// emitModuleGraph performs) and records the Graphviz ID prefix that owns each node.
+func collectPrefixes(g *graph, prefix string, depth int, prefixes map[node]string) {
+	for _, p := range g.providers {
+		prefixes[p] = prefix
+	}
+	for _, r := range g.resources {
+		prefixes[r] = prefix
+	}
+	for _, l := range g.locals {
+		prefixes[l] = prefix
+	}
+	for _, v := range g.variables {
+		prefixes[v] = prefix
+	}
+	for _, o := range g.outputs {
+		prefixes[o] = prefix
+	}
+	for _, mn := range g.modules {
+		prefixes[mn] = prefix
+		if depth == 0 || mn.graph == nil {
+			continue
+		}
+		childDepth := depth
+		if childDepth > 0 {
+			childDepth--
+		}
+		collectPrefixes(mn.graph, prefix+mn.name+".", childDepth, prefixes)
+	}
+}
+
+func emitModuleGraph(b *strings.Builder, g *graph, prefix string, opts *GraphDotOpts, prefixes map[node]string) {
+	var cycles map[edge]bool
+	if opts.DrawCycles {
+		cycles = detectCycles(allNodes(g))
+	}
+
+	for _, p := range g.providers {
+		writeNodeDecl(b, prefix, p, opts)
+	}
+	for _, r := range g.resources {
+		writeNodeDecl(b, prefix, r, opts)
+		writeEdge(b, prefixes, r, r.provider, false, cycles)
+		for _, dep := range r.deps {
+			writeEdge(b, prefixes, r, dep, containsNode(r.explicitDeps, dep), cycles)
+		}
+	}
+	for _, l := range g.locals {
+		writeNodeDecl(b, prefix, l, opts)
+		for _, dep := range l.deps {
+			writeEdge(b, prefixes, l, dep, false, cycles)
+		}
+	}
+	for _, v := range g.variables {
+		writeNodeDecl(b, prefix, v, opts)
+	}
+	for _, o := range g.outputs {
+		writeNodeDecl(b, prefix, o, opts)
+		for _, dep := range o.deps {
+			writeEdge(b, prefixes, o, dep, containsNode(o.explicitDeps, dep), cycles)
+		}
+	}
+
+	for _, mn := range g.modules {
+		writeNodeDecl(b, prefix, mn, opts)
+		for _, dep := range mn.deps {
+			writeEdge(b, prefixes, mn, dep, false, cycles)
+		}
+
+		if opts.ModuleDepth == 0 || mn.graph == nil {
+			continue
+		}
+		childOpts := *opts
+		if childOpts.ModuleDepth > 0 {
+			childOpts.ModuleDepth--
+		}
+		childPrefix := prefix + mn.name + "."
+		fmt.Fprintf(b, "  subgraph \"cluster_%s\" {\n    label=%q;\n", childPrefix, mn.name)
+		emitModuleGraph(b, mn.graph, childPrefix, &childOpts, prefixes)
+		b.WriteString("  }\n")
+	}
+}
+
+func writeNodeDecl(b *strings.Builder, prefix string, n node, opts *GraphDotOpts) {
+	if n == nil {
+		return
+	}
+	fmt.Fprintf(b, "  %q [label=%q];\n", nodeID(prefix, n), nodeLabel(prefix, n, opts.Verbose))
+}
+
+// writeEdge resolves from and to against prefixes independently, since an edge may cross a module boundary and have
+// its two endpoints declared under different subgraph prefixes.
+func writeEdge(b *strings.Builder, prefixes map[node]string, from, to node, explicit bool, cycles map[edge]bool) {
+	if to == nil {
+		return
+	}
+	style := "solid"
+	if explicit {
+		style = "dashed"
+	}
+	color := "black"
+	if cycles[edge{from, to}] {
+		color = "red"
+	}
+	fmt.Fprintf(b, "  %q -> %q [style=%s, color=%s];\n", nodeID(prefixes[from], from), nodeID(prefixes[to], to), style, color)
+}
+
+func containsNode(haystack []node, needle node) bool {
+	for _, n := range haystack {
+		if n == needle {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeID(prefix string, n node) string {
+	switch v := n.(type) {
+	case *providerNode:
+		return prefix + "provider." + v.Name()
+	case *resourceNode:
+		id := prefix + v.config.Id()
+		if v.index >= 0 {
+			id = fmt.Sprintf("%s[%d]", id, v.index)
+		}
+		return id
+	case *outputNode:
+		return prefix + "output." + v.config.Name
+	case *localNode:
+		return prefix + "local." + v.config.Name
+	case *variableNode:
+		return prefix + "var." + v.config.Name
+	case *moduleNode:
+		return prefix + "module." + v.name
+	default:
+		return fmt.Sprintf("%sunknown.%p", prefix, n)
+	}
+}
+
+func nodeLabel(prefix string, n node, verbose bool) string {
+	id := nodeID(prefix, n)
+	if !verbose {
+		return id
+	}
+	r, ok := n.(*resourceNode)
+	if !ok || r.provider == nil || r.provider.info == nil {
+		return id
+	}
+	info, ok := r.provider.info.Resources[r.config.Type]
+	if !ok {
+		return id
+	}
+	return fmt.Sprintf("%s\n%s", id, info.Tok)
+}
+
+// edge identifies a single dependency edge for the purposes of cycle highlighting.
+type edge struct {
+	from, to node
+}
+
+// detectCycles runs a standard white/gray/black DFS over nodes' dependency edges and returns the set of edges that
+// close a cycle (i.e. point back at a node still on the current DFS stack).
+func detectCycles(nodes []node) map[edge]bool {
+	const (
+		white = iota
+		gray
+		black
+	)
+	color := make(map[node]int, len(nodes))
+
+	cycles := make(map[edge]bool)
+	var visit func(n node)
+	visit = func(n node) {
+		color[n] = gray
+		for _, dep := range n.dependencies() {
+			switch color[dep] {
+			case gray:
+				cycles[edge{n, dep}] = true
+			case white:
+				visit(dep)
+			}
+		}
+		color[n] = black
+	}
+
+	for _, n := range nodes {
+		if color[n] == white {
+			visit(n)
+		}
+	}
+	return cycles
+}
+
+// allNodes flattens every node directly owned by g (not recursing into child modules) into a single slice for
+// detectCycles to start its walk from. Each module's subgraph is cycle-checked by its own emitModuleGraph call; the
+// walk itself follows dependencies() wherever they lead, including into nodes owned by another module.
+func allNodes(g *graph) []node {
+	nodes := make([]node, 0, len(g.providers)+len(g.resources)+len(g.outputs)+len(g.locals)+len(g.variables)+len(g.modules))
+	for _, p := range g.providers {
+		nodes = append(nodes, p)
+	}
+	for _, r := range g.resources {
+		nodes = append(nodes, r)
+	}
+	for _, o := range g.outputs {
+		nodes = append(nodes, o)
+	}
+	for _, l := range g.locals {
+		nodes = append(nodes, l)
+	}
+	for _, v := range g.variables {
+		nodes = append(nodes, v)
+	}
+	for _, m := range g.modules {
+		nodes = append(nodes, m)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodeID("", nodes[i]) < nodeID("", nodes[j]) })
+	return nodes
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+)
+
+func TestNodeID(t *testing.T) {
+	mn := &moduleNode{name: "child"}
+	if got, want := nodeID("", mn), "module.child"; got != want {
+		t.Fatalf("nodeID(%q) = %q, want %q", "", got, want)
+	}
+	if got, want := nodeID("parent.", mn), "parent.module.child"; got != want {
+		t.Fatalf("nodeID(%q) = %q, want %q", "parent.", got, want)
+	}
+
+	o := &outputNode{config: &config.Output{Name: "bar"}}
+	if got, want := nodeID("", o), "output.bar"; got != want {
+		t.Fatalf("nodeID(output) = %q, want %q", got, want)
+	}
+
+	l := &localNode{config: &config.Local{Name: "baz"}}
+	if got, want := nodeID("", l), "local.baz"; got != want {
+		t.Fatalf("nodeID(local) = %q, want %q", got, want)
+	}
+
+	v := &variableNode{config: &config.Variable{Name: "qux"}}
+	if got, want := nodeID("", v), "var.qux"; got != want {
+		t.Fatalf("nodeID(variable) = %q, want %q", got, want)
+	}
+}
+
+// fakeNode satisfies the node interface without matching any of nodeID's known cases, exercising its default branch.
+type fakeNode struct{}
+
+func (*fakeNode) dependencies() []node { return nil }
+
+func TestNodeIDUnknownType(t *testing.T) {
+	if got := nodeID("", &fakeNode{}); !strings.Contains(got, "unknown.") {
+		t.Fatalf("expected an unrecognized node type to fall back to an \"unknown.\" ID, got %q", got)
+	}
+}
+
+func TestCollectPrefixes(t *testing.T) {
+	child := &graph{locals: []*localNode{{}}}
+	mn := &moduleNode{name: "child", graph: child}
+	root := &graph{outputs: []*outputNode{{}}, modules: []*moduleNode{mn}}
+
+	prefixes := make(map[node]string)
+	collectPrefixes(root, "", -1, prefixes)
+
+	if prefixes[root.outputs[0]] != "" {
+		t.Fatalf("expected root output's prefix to be \"\", got %q", prefixes[root.outputs[0]])
+	}
+	if prefixes[mn] != "" {
+		t.Fatalf("expected module node's own prefix to be \"\", got %q", prefixes[mn])
+	}
+	if got, want := prefixes[child.locals[0]], "child."; got != want {
+		t.Fatalf("expected child local's prefix to be %q, got %q", want, got)
+	}
+}
+
+func TestCollectPrefixesStopsAtModuleDepth(t *testing.T) {
+	child := &graph{locals: []*localNode{{}}}
+	mn := &moduleNode{name: "child", graph: child}
+	root := &graph{modules: []*moduleNode{mn}}
+
+	prefixes := make(map[node]string)
+	collectPrefixes(root, "", 0, prefixes)
+
+	if _, ok := prefixes[mn]; !ok {
+		t.Fatal("expected the module node itself to still get a prefix at depth 0")
+	}
+	if _, ok := prefixes[child.locals[0]]; ok {
+		t.Fatal("expected depth 0 to stop before recursing into the child module's own nodes")
+	}
+}
+
+func TestDetectCycles(t *testing.T) {
+	a, b, c := &localNode{}, &localNode{}, &localNode{}
+	a.deps = []node{b}
+	b.deps = []node{c}
+	c.deps = []node{a}
+
+	d, e := &localNode{}, &localNode{}
+	d.deps = []node{e}
+
+	cycles := detectCycles([]node{a, b, c, d, e})
+	if len(cycles) != 1 {
+		t.Fatalf("expected exactly one cyclic edge, got %d: %#v", len(cycles), cycles)
+	}
+	if !cycles[edge{c, a}] {
+		t.Fatalf("expected the edge closing the cycle (c -> a) to be flagged, got %#v", cycles)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/hashicorp/hil"
 	"github.com/hashicorp/hil/ast"
 	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/config/module"
 	"github.com/pkg/errors"
 	"github.com/pulumi/pulumi/pkg/workspace"
 	"github.com/pulumi/pulumi/pkg/util/contract"
@@ -27,6 +28,16 @@ type graph struct {
 	outputs []*outputNode
 	locals []*localNode
 	variables []*variableNode
+	modules []*moduleNode
+
+	// tree, inputs, and inherited carry the module-tree context a GraphTransformer needs while the graph is being
+	// assembled (see ConfigTransformer and ReferenceTransformer in transform.go); b accumulates the name->node maps
+	// the pipeline's passes build up as they run. None of the three are meaningful once TransformerSequence.Transform
+	// has returned.
+	tree      *module.Tree
+	inputs    map[string]interface{}
+	inherited map[string]*providerNode
+	b         *builder
 }
 
 type providerNode struct {
@@ -42,6 +53,14 @@ type resourceNode struct {
 	deps []node
 	explicitDeps []node
 	properties map[string]interface{}
+
+	// count holds this resource's `count` expansion, or nil if the resource has no `count` at all. See
+	// CountBoundaryTransformer in count.go.
+	count *countInfo
+	// index is this node's position within its parent's count.instances, or -1 if this node is not itself one of
+	// those expanded instances (i.e. it has no count, it is the template a literal count was expanded from, or it is
+	// the stand-in for a runtime count, flagged by count.dynamic).
+	index int
 }
 
 type outputNode struct {
@@ -62,10 +81,28 @@ type variableNode struct {
 	defaultValue interface{}
 }
 
+// moduleNode represents a single instantiation of a child module (a `module "..." { source = "..." }` block). Its
+// graph is built independently of its parent's, so names inside the module never collide with names at the call
+// site; the module's inputs are threaded into the child graph as variable defaults, and its outputs are exposed to
+// the parent via buildValue's `*config.ModuleVariable` handling below.
+type moduleNode struct {
+	name string
+	config *config.Module
+	deps []node
+	graph *graph
+}
+
 func (p *providerNode) dependencies() []node {
 	return p.deps
 }
 
+// Name returns the stable identifier for this provider's construction (e.g. "aws" or "aws.west") that the resource
+// emitter uses both to name the Pulumi provider resource it constructs and to reference it from the `provider`
+// option of every resource that depends on it.
+func (p *providerNode) Name() string {
+	return p.config.FullName()
+}
+
 func (r *resourceNode) dependencies() []node {
 	return r.deps
 }
@@ -82,12 +119,17 @@ func (v *variableNode) dependencies() []node {
 	return nil
 }
 
+func (m *moduleNode) dependencies() []node {
+	return m.deps
+}
+
 type builder struct {
 	providers map[string]*providerNode
 	resources map[string]*resourceNode
 	outputs   map[string]*outputNode
 	locals    map[string]*localNode
 	variables map[string]*variableNode
+	modules   map[string]*moduleNode
 }
 
 func newBuilder() *builder {
@@ -97,6 +139,7 @@ func newBuilder() *builder {
 		outputs:   make(map[string]*outputNode),
 		locals:    make(map[string]*localNode),
 		variables: make(map[string]*variableNode),
+		modules:   make(map[string]*moduleNode),
 	}
 }
 
@@ -116,9 +159,26 @@ func (b *builder) getNode(name string) (node, bool) {
 	if v, ok := b.variables[name]; ok {
 		return v, true
 	}
+	if m, ok := b.modules[name]; ok {
+		return m, true
+	}
 	return nil, false
 }
 
+// moduleOutput resolves the output named field on the child graph belonging to mn, returning the outputNode so that
+// callers can record it as a dependency of whatever is consuming `module.mn.field`.
+func (b *builder) moduleOutput(mn *moduleNode, field string) (*outputNode, error) {
+	if mn.graph == nil {
+		return nil, errors.Errorf("module %v has not been built yet", mn.name)
+	}
+	for _, o := range mn.graph.outputs {
+		if o.config.Name == field {
+			return o, nil
+		}
+	}
+	return nil, errors.Errorf("unknown output %v on module %v", field, mn.name)
+}
+
 type propertyWalker struct {
 	deps map[string]struct{}
 }
@@ -142,15 +202,32 @@ func (w *propertyWalker) walkPrimitive(p reflect.Value) (interface{}, error) {
 			return lit.Value, nil
 		}
 
+		// Rewrite any Terraform builtins invoked in this expression into either their compile-time result or a
+		// tagged node the emitter knows how to lower, before collecting the dependencies left in the tree.
+		rootNode, err = translateFunctions(rootNode)
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := rootNode.(*ast.LiteralNode); ok {
+			return lit.Value, nil
+		}
+
+		// Likewise, tag any splat reference (`aws_instance.foo.*.id`) so that once the referenced resource's count
+		// is known, the emitter can lower it to the target language's own map-over-instances equivalent.
+		rootNode, err = rewriteSplatAccess(rootNode)
+		if err != nil {
+			return nil, err
+		}
+
 		rootNode.Accept(func(n ast.Node) ast.Node {
-			if v, ok := n.(*ast.VariableAccess); ok {
+			switch v := n.(type) {
+			case *ast.VariableAccess:
+				w.deps[v.Name] = struct{}{}
+			case *ilSplatVariableAccess:
 				w.deps[v.Name] = struct{}{}
 			}
 			return n
 		})
-		if err != nil {
-			return nil, err
-		}
 		return rootNode, nil
 
 	default:
@@ -243,8 +320,15 @@ func (b *builder) buildValue(v interface{}) (interface{}, map[node]struct{}, err
 			// nothing to do
 
 		case *config.ModuleVariable:
-			// unsupported
-			return nil, nil, errors.Errorf("module variable references are not yet supported (%v)", v.Name)
+			mn, ok := b.modules[v.Name]
+			if !ok {
+				return nil, nil, errors.Errorf("unknown module %v", v.Name)
+			}
+			o, err := b.moduleOutput(mn, v.Field)
+			if err != nil {
+				return nil, nil, err
+			}
+			deps[o] = struct{}{}
 
 		case *config.LocalVariable:
 			l, ok := b.locals[v.Name]
@@ -257,7 +341,9 @@ func (b *builder) buildValue(v interface{}) (interface{}, map[node]struct{}, err
 			if !ok {
 				return nil, nil, errors.Errorf("unknown resource %v", v.Name)
 			}
-			deps[r] = struct{}{}
+			if err := b.addResourceDeps(deps, r, v); err != nil {
+				return nil, nil, err
+			}
 		case *config.UserVariable:
 			u, ok := b.variables[v.Name]
 			if !ok {
@@ -278,25 +364,59 @@ func (b *builder) buildProperties(raw *config.RawConfig) (map[string]interface{}
 	return v.(map[string]interface{}), deps, nil
 }
 
-func (b *builder) buildDeps(deps map[node]struct{}, dependsOn []string) ([]node, []node, error) {
-	explicitDeps := make([]node, len(dependsOn))
-	for i, name := range dependsOn {
+// buildDeps flattens the set of implicit (HIL-derived) dependencies collected by buildValue into a slice. Explicit
+// `depends_on` dependencies are resolved separately by resolveExplicitDeps and merged in by DependsOnTransformer.
+func (b *builder) buildDeps(deps map[node]struct{}) []node {
+	allDeps := make([]node, 0, len(deps))
+	for n := range deps {
+		allDeps = append(allDeps, n)
+	}
+	return allDeps
+}
+
+// resolveExplicitDeps resolves the resource and module names listed in a `depends_on` block to their nodes. A
+// `depends_on` entry names a whole resource rather than a specific instance, so a count-expanded resource
+// contributes one explicit dep per instance.
+func (b *builder) resolveExplicitDeps(dependsOn []string) ([]node, error) {
+	explicitDeps := make([]node, 0, len(dependsOn))
+	for _, name := range dependsOn {
 		if strings.HasPrefix(name, "module.") {
-			return nil, nil, errors.Errorf("module references are not yet supported (%v)", name)
+			mn, ok := b.modules[strings.TrimPrefix(name, "module.")]
+			if !ok {
+				return nil, errors.Errorf("unknown module %v", name)
+			}
+			explicitDeps = append(explicitDeps, mn)
+			continue
 		}
 		r, ok := b.resources[name]
 		if !ok {
-			return nil, nil, errors.Errorf("unknown resource %v", name)
+			return nil, errors.Errorf("unknown resource %v", name)
+		}
+		if r.count != nil && !r.count.dynamic {
+			for _, instance := range r.count.instances {
+				explicitDeps = append(explicitDeps, instance)
+			}
+			continue
 		}
-		deps[r], explicitDeps[i] = struct{}{}, r
+		explicitDeps = append(explicitDeps, r)
 	}
+	return explicitDeps, nil
+}
 
-	allDeps := make([]node, 0, len(deps))
-	for n, _ := range deps {
-		allDeps = append(allDeps, n)
+// mergeDeps appends any node in extra that is not already present in existing, preserving existing's order.
+func mergeDeps(existing, extra []node) []node {
+	seen := make(map[node]struct{}, len(existing))
+	for _, n := range existing {
+		seen[n] = struct{}{}
+	}
+	result := existing
+	for _, n := range extra {
+		if _, ok := seen[n]; !ok {
+			seen[n] = struct{}{}
+			result = append(result, n)
+		}
 	}
-
-	return allDeps, explicitDeps, nil
+	return result
 }
 
 func getProviderInfo(p *providerNode) (*tfbridge.ProviderInfo, error) {
@@ -338,30 +458,43 @@ func (b *builder) buildProvider(p *providerNode) error {
 	if err != nil {
 		return err
 	}
-	allDeps, _, err := b.buildDeps(deps, nil)
-	contract.Assert(err == nil)
 
-	p.properties, p.deps = props, allDeps
+	p.properties, p.deps = props, b.buildDeps(deps)
 	return nil
 }
 
+// buildResource resolves a resource's own properties and implicit dependencies. Attaching its providerNode is
+// ProviderTransformer's job, and merging in its explicit `depends_on` edges is DependsOnTransformer's, so that each
+// concern lives in the pass that owns it. r.count, if set, is necessarily a dynamic count here--a literal count is
+// expanded into instances that buildResourceInstance builds instead, and CountBoundaryTransformer's own count
+// expression dependencies (r.count.deps) are merged in alongside r's own.
 func (b *builder) buildResource(r *resourceNode) error {
-	providerName := r.config.ProviderFullName()
-	p, ok := b.providers[providerName]
-	if !ok {
-		return errors.Errorf("could not find provider for resource %s", r.config.Id())
-	}
-	r.provider = p
-
 	props, deps, err := b.buildProperties(r.config.RawConfig)
 	if err != nil {
 		return err
 	}
-	allDeps, explicitDeps, err := b.buildDeps(deps, r.config.DependsOn)
+	allDeps := b.buildDeps(deps)
+	if r.count != nil {
+		allDeps = mergeDeps(allDeps, r.count.deps)
+	}
+	r.properties, r.deps = props, allDeps
+	return nil
+}
+
+// buildResourceInstance builds one indexed instance of a resource whose literal count CountBoundaryTransformer has
+// already expanded, substituting the concrete index for `count.index` in its properties. countDeps carries the
+// count expression's own dependencies (e.g. the variable that determined how many instances to create), which are
+// merged into every instance alongside whatever that instance's own properties depend on.
+func (b *builder) buildResourceInstance(instance *resourceNode, countDeps []node) error {
+	props, deps, err := b.buildProperties(instance.config.RawConfig)
 	if err != nil {
 		return err
 	}
-	r.properties, r.deps, r.explicitDeps = props, allDeps, explicitDeps
+	properties, err := substituteCountIndex(props, instance.index)
+	if err != nil {
+		return errors.Wrapf(err, "expanding %v[%d]", instance.config.Id(), instance.index)
+	}
+	instance.properties, instance.deps = properties, mergeDeps(b.buildDeps(deps), countDeps)
 	return nil
 }
 
@@ -370,10 +503,7 @@ func (b *builder) buildOutput(o *outputNode) error {
 	if err != nil {
 		return err
 	}
-	allDeps, explicitDeps, err := b.buildDeps(deps, o.config.DependsOn)
-	if err != nil {
-		return err
-	}
+	allDeps := b.buildDeps(deps)
 
 	// In general, an output should have a single property named "value". If this is the case, promote it to the
 	// output's value.
@@ -384,7 +514,7 @@ func (b *builder) buildOutput(o *outputNode) error {
 		}
 	}
 
-	o.value, o.deps, o.explicitDeps = value, allDeps, explicitDeps
+	o.value, o.deps = value, allDeps
 	return nil
 }
 
@@ -393,14 +523,18 @@ func (b *builder) buildLocal(l *localNode) error {
 	if err != nil {
 		return err
 	}
-	allDeps, _, err := b.buildDeps(deps, nil)
-	contract.Assert(err == nil)
-
-	l.properties, l.deps = props, allDeps
+	l.properties, l.deps = props, b.buildDeps(deps)
 	return nil
 }
 
-func (b *builder) buildVariable(v *variableNode) error {
+// buildVariable resolves a variable's value. If the caller (either the top-level config or, for a child module, the
+// module block that instantiated it) supplied an explicit input, that input wins over the variable's own default.
+func (b *builder) buildVariable(v *variableNode, input interface{}, hasInput bool) error {
+	if hasInput {
+		v.defaultValue = input
+		return nil
+	}
+
 	defaultValue, deps, err := b.buildValue(v.config.Default)
 	if err != nil {
 		return err
@@ -412,81 +546,38 @@ func (b *builder) buildVariable(v *variableNode) error {
 	return nil
 }
 
-func buildGraph(conf *config.Config) (*graph, error) {
-	b := newBuilder()
-
-	// First create our nodes.
-	for _, p := range conf.ProviderConfigs {
-		b.providers[p.Name] = &providerNode{config: p}
-	}
-	for _, r := range conf.Resources {
-		b.resources[r.Id()] = &resourceNode{config: r}
-	}
-	for _, o := range conf.Outputs {
-		b.outputs[o.Name] = &outputNode{config: o}
-	}
-	for _, l := range conf.Locals {
-		b.locals[l.Name] = &localNode{config: l}
-	}
-	for _, v := range conf.Variables {
-		b.variables[v.Name] = &variableNode{config: v}
+// buildModule evaluates a module block's inputs in the parent's scope, builds the child module's graph--passing
+// those inputs down as variable defaults--and records the module's dependencies on the parent graph.
+func (b *builder) buildModule(mn *moduleNode, childTree *module.Tree, inherited map[string]*providerNode) error {
+	inputs, deps, err := b.buildProperties(mn.config.RawConfig)
+	if err != nil {
+		return err
 	}
 
-	// Now translate each node's properties and connect any dependency edges.
-	for _, p := range b.providers {
-		if err := b.buildProvider(p); err != nil {
-			return nil, err
-		}
-	}
-	for _, r := range b.resources {
-		if err := b.buildResource(r); err != nil {
-			return nil, err
-		}
-	}
-	for _, o := range b.outputs {
-		if err := b.buildOutput(o); err != nil {
-			return nil, err
-		}
-		// outputs are sinks; we always deal with them last
-	}
-	for _, l := range b.locals {
-		if err := b.buildLocal(l); err != nil {
-			return nil, err
-		}
-	}
-	for _, v := range b.variables {
-		if err := b.buildVariable(v); err != nil {
-			return nil, err
-		}
-		// variables are sources; we always deal with them before other nodes.
+	childGraph, err := buildModuleGraph(childTree, inputs, inherited)
+	if err != nil {
+		return errors.Wrapf(err, "building module %v", mn.name)
 	}
 
-	// put the graph together
-	providers := make([]*providerNode, 0, len(b.providers))
-	for _, p := range b.providers {
-		providers = append(providers, p)
-	}
-	resources := make([]*resourceNode, 0, len(b.resources))
-	for _, r := range b.resources {
-		resources = append(resources, r)
-	}
-	outputs := make([]*outputNode, 0, len(b.outputs))
-	for _, o := range b.outputs {
-		outputs = append(outputs, o)
-	}
-	locals := make([]*localNode, 0, len(b.locals))
-	for _, l := range b.locals {
-		locals = append(locals, l)
-	}
-	variables := make([]*variableNode, 0, len(b.variables))
-	for _, v := range b.variables {
-		variables = append(variables, v)
+	mn.graph, mn.deps = childGraph, b.buildDeps(deps)
+	return nil
+}
+
+// buildGraph builds the graph for an entire configuration, including any child modules referenced via
+// `module "..." { source = "..." }` blocks. tree must already be loaded (e.g. via module.NewTreeModule followed by
+// Tree.Load) so that config.ModuleVariable references and nested module blocks can be resolved.
+func buildGraph(tree *module.Tree) (*graph, error) {
+	return buildModuleGraph(tree, nil, nil)
+}
+
+// buildModuleGraph builds the graph for a single node of the module tree by running defaultTransformers over it.
+// inputs carries the resolved values of the module block that instantiated this module (nil for the root);
+// inherited carries the parent's provider nodes so that providers implicitly passed down to child modules are
+// shared rather than re-instantiated per module.
+func buildModuleGraph(tree *module.Tree, inputs map[string]interface{}, inherited map[string]*providerNode) (*graph, error) {
+	g := &graph{tree: tree, inputs: inputs, inherited: inherited, b: newBuilder()}
+	if err := defaultTransformers.Transform(g); err != nil {
+		return nil, err
 	}
-	return &graph{
-		providers: providers,
-		resources: resources,
-		outputs: outputs,
-		locals: locals,
-		variables: variables,
-	}, nil
+	return g, nil
 }
\ No newline at end of file
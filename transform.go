@@ -0,0 +1,296 @@
+package main
+
+import (
+	"github.com/hashicorp/terraform/config"
+	"github.com/pkg/errors"
+)
+
+// GraphTransformer is a single pass over a graph under construction. Passes run in sequence (see
+// TransformerSequence) and mutate the graph and its backing builder in place, mirroring Terraform core's own
+// transform-stage design: each concern--creating nodes, attaching providers, resolving references, wiring explicit
+// dependencies, and so on--lives in its own pass, so a caller can splice in an additional transformer (to inject a
+// synthetic resource, strip a provider, rewrite edges, ...) without forking buildGraph.
+type GraphTransformer interface {
+	Transform(g *graph) error
+}
+
+// GraphTransformerFunc adapts a plain function to the GraphTransformer interface.
+type GraphTransformerFunc func(g *graph) error
+
+func (f GraphTransformerFunc) Transform(g *graph) error { return f(g) }
+
+// TransformerSequence runs a list of GraphTransformers over a graph in order, stopping at the first error.
+type TransformerSequence []GraphTransformer
+
+func (s TransformerSequence) Transform(g *graph) error {
+	for _, t := range s {
+		if err := t.Transform(g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultTransformers is the pipeline buildModuleGraph runs over every module in the tree. Its order matters:
+// providers must exist before resources can be attached to them; child modules must be built before
+// CountBoundaryTransformer can tell whether a count driven by a module output ultimately traces back to a resource
+// attribute; counts must be resolved into instances before ReferenceTransformer lets anything else reference those
+// resources, so a splat or indexed reference targets the actual instances rather than the pre-expansion template;
+// and the graph's public slices aren't populated until RootTransformer runs last.
+var defaultTransformers = TransformerSequence{
+	&ConfigTransformer{},
+	&ProviderTransformer{},
+	&ModuleTransformer{},
+	&LocalTransformer{},
+	&CountBoundaryTransformer{},
+	&ReferenceTransformer{},
+	&DependsOnTransformer{},
+	&OrphanTransformer{},
+	&RootTransformer{},
+}
+
+// ConfigTransformer creates a node--without yet resolving its properties or dependency edges--for every provider,
+// resource, output, local, variable, and module block in g.tree's configuration. Provider blocks this module
+// inherited from its parent (g.inherited) are merged with any same-named block declared locally; see
+// mergeProviderConfig.
+type ConfigTransformer struct{}
+
+func (t *ConfigTransformer) Transform(g *graph) error {
+	conf, b := g.tree.Config(), g.b
+
+	for fullName, p := range g.inherited {
+		b.providers[fullName] = p
+	}
+	for _, p := range conf.ProviderConfigs {
+		fullName := p.FullName()
+		if existing, ok := g.inherited[fullName]; ok {
+			merged, err := mergeProviderConfig(existing.config, p)
+			if err != nil {
+				return err
+			}
+			b.providers[fullName] = &providerNode{config: merged}
+		} else {
+			b.providers[fullName] = &providerNode{config: p}
+		}
+	}
+	for _, r := range conf.Resources {
+		b.resources[r.Id()] = &resourceNode{config: r, index: -1}
+	}
+	for _, o := range conf.Outputs {
+		b.outputs[o.Name] = &outputNode{config: o}
+	}
+	for _, l := range conf.Locals {
+		b.locals[l.Name] = &localNode{config: l}
+	}
+	for _, v := range conf.Variables {
+		b.variables[v.Name] = &variableNode{config: v}
+	}
+	for _, m := range conf.Modules {
+		b.modules[m.Name] = &moduleNode{name: m.Name, config: m}
+	}
+	return nil
+}
+
+// mergeProviderConfig unifies two provider blocks that configure the same (name, alias) pair--e.g. one declared by a
+// parent module and re-declared (perhaps partially) by one of its children--using config.Merge, the merge Terraform
+// core uses to combine a module's config with its override files: override's attributes are layered over base's.
+func mergeProviderConfig(base, override *config.ProviderConfig) (*config.ProviderConfig, error) {
+	merged, err := config.Merge(
+		&config.Config{ProviderConfigs: []*config.ProviderConfig{base}},
+		&config.Config{ProviderConfigs: []*config.ProviderConfig{override}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return merged.ProviderConfigs[0], nil
+}
+
+// ProviderTransformer resolves each providerNode's own properties (skipping any inherited from a parent module,
+// which are already built) and attaches the correct providerNode to every resourceNode.
+type ProviderTransformer struct{}
+
+func (t *ProviderTransformer) Transform(g *graph) error {
+	b := g.b
+	for _, p := range b.providers {
+		if p.properties == nil {
+			if err := b.buildProvider(p); err != nil {
+				return err
+			}
+		}
+	}
+	for _, r := range b.resources {
+		providerName := r.config.ProviderFullName()
+		p, ok := b.providers[providerName]
+		if !ok {
+			if r.config.Provider != "" {
+				return errors.Errorf(
+					"resource %s references provider alias %q, but no `provider %q { alias = ... }` block is "+
+						"configured", r.config.Id(), r.config.Provider, providerName)
+			}
+			return errors.Errorf("could not find provider for resource %s", r.config.Id())
+		}
+		r.provider = p
+	}
+	return nil
+}
+
+// ModuleTransformer builds every child module block's graph, passing its resolved inputs down as variable defaults.
+// It runs before CountBoundaryTransformer so that a count expression referencing a module output (`module.foo.bar`)
+// can see that output's own dependency graph when deciding whether the count ultimately traces back to a resource
+// attribute--see count.go. A module input referencing a counted sibling resource by splat or index is therefore
+// resolved against that resource's pre-expansion template rather than its instances, a narrow gap this tree accepts.
+type ModuleTransformer struct{}
+
+func (t *ModuleTransformer) Transform(g *graph) error {
+	b := g.b
+	for _, mn := range b.modules {
+		childTree := g.tree.Children()[mn.name]
+		if childTree == nil {
+			return errors.Errorf("no loaded module for %v", mn.name)
+		}
+		if err := b.buildModule(mn, childTree, b.providers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LocalTransformer resolves every local value's own properties and implicit dependencies. It runs before
+// CountBoundaryTransformer, alongside ModuleTransformer, for the same reason and with the same pre-expansion-template
+// caveat described on ModuleTransformer.
+type LocalTransformer struct{}
+
+func (t *LocalTransformer) Transform(g *graph) error {
+	b := g.b
+	for _, l := range b.locals {
+		if err := b.buildLocal(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReferenceTransformer resolves every HIL variable reference left in a resource's, output's, or variable's raw
+// config into a dependency edge on the node it names. It runs after CountBoundaryTransformer, so for a resource whose
+// count was resolved to a literal it builds each of the instances that pass created--each instance's own properties are built
+// fresh (rather than by substituting the already-built template, which isn't built at all for a count-expanded
+// resource) so that any reference to another resource correctly sees that resource's count, too.
+type ReferenceTransformer struct{}
+
+func (t *ReferenceTransformer) Transform(g *graph) error {
+	b := g.b
+	for _, r := range b.resources {
+		if r.count != nil && !r.count.dynamic {
+			for _, instance := range r.count.instances {
+				if err := b.buildResourceInstance(instance, r.count.deps); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := b.buildResource(r); err != nil {
+			return err
+		}
+	}
+	for _, v := range b.variables {
+		input, hasInput := g.inputs[v.config.Name]
+		if err := b.buildVariable(v, input, hasInput); err != nil {
+			return err
+		}
+	}
+	for _, o := range b.outputs {
+		if err := b.buildOutput(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DependsOnTransformer resolves each resource's and output's explicit `depends_on` list into edges, in addition to
+// (rather than instead of) the implicit edges ReferenceTransformer already recorded. A `depends_on` entry applies
+// identically to every instance of a count-expanded resource, so it is resolved once per resource and then merged
+// into each instance rather than into the (by now unused) template.
+type DependsOnTransformer struct{}
+
+func (t *DependsOnTransformer) Transform(g *graph) error {
+	b := g.b
+	for _, r := range b.resources {
+		explicit, err := b.resolveExplicitDeps(r.config.DependsOn)
+		if err != nil {
+			return err
+		}
+		if r.count != nil && !r.count.dynamic {
+			for _, instance := range r.count.instances {
+				instance.explicitDeps, instance.deps = explicit, mergeDeps(instance.deps, explicit)
+			}
+			continue
+		}
+		r.explicitDeps, r.deps = explicit, mergeDeps(r.deps, explicit)
+	}
+	for _, o := range b.outputs {
+		explicit, err := b.resolveExplicitDeps(o.config.DependsOn)
+		if err != nil {
+			return err
+		}
+		o.explicitDeps, o.deps = explicit, mergeDeps(o.deps, explicit)
+	}
+	return nil
+}
+
+// CountBoundaryTransformer determines each resource's `count` and, for a literal count, creates its indexed
+// instances; see count.go. It runs before ReferenceTransformer builds any properties, so that node is the one that
+// decides whether a reference to a counted resource should land on the template or on specific instances.
+
+// OrphanTransformer is reserved for future diff support: it is where resources present in a prior state but no
+// longer present in config would be marked for destroy.
+type OrphanTransformer struct{}
+
+func (t *OrphanTransformer) Transform(g *graph) error {
+	return nil
+}
+
+// RootTransformer copies the builder's accumulated name->node maps into the graph's public slices. It must run last
+// so that every earlier pass has finished mutating the nodes those slices will expose.
+type RootTransformer struct{}
+
+func (t *RootTransformer) Transform(g *graph) error {
+	b := g.b
+
+	// A provider inherited unchanged from a parent module (g.inherited) is the very same *providerNode the parent's
+	// own g.providers already exposes; re-exposing it here too would let it end up declared under more than one
+	// module's prefix (GraphDot, in particular, identifies a node by its pointer, and a node can only live in one
+	// subgraph). Only a provider this module newly declared, or locally merged with an override, belongs here.
+	g.providers = make([]*providerNode, 0, len(b.providers))
+	for fullName, p := range b.providers {
+		if inherited, ok := g.inherited[fullName]; ok && inherited == p {
+			continue
+		}
+		g.providers = append(g.providers, p)
+	}
+	g.resources = make([]*resourceNode, 0, len(b.resources))
+	for _, r := range b.resources {
+		// A resource with a literal count never itself appears in the graph--its expanded instances do.
+		if r.count != nil && !r.count.dynamic {
+			g.resources = append(g.resources, r.count.instances...)
+			continue
+		}
+		g.resources = append(g.resources, r)
+	}
+	g.outputs = make([]*outputNode, 0, len(b.outputs))
+	for _, o := range b.outputs {
+		g.outputs = append(g.outputs, o)
+	}
+	g.locals = make([]*localNode, 0, len(b.locals))
+	for _, l := range b.locals {
+		g.locals = append(g.locals, l)
+	}
+	g.variables = make([]*variableNode, 0, len(b.variables))
+	for _, v := range b.variables {
+		g.variables = append(g.variables, v)
+	}
+	g.modules = make([]*moduleNode, 0, len(b.modules))
+	for _, m := range b.modules {
+		g.modules = append(g.modules, m)
+	}
+	return nil
+}